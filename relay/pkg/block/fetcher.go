@@ -0,0 +1,308 @@
+package block
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-log"
+
+	"github.com/keep-network/tbtc/relay/pkg/btc"
+	"github.com/keep-network/tbtc/relay/pkg/chain"
+)
+
+const (
+	// announcementsChannelSize is the size of the channel the fetcher
+	// subscribes its new head announcements on.
+	announcementsChannelSize = 16
+
+	// defaultOrphanTTL is the default duration an announced header whose
+	// parent has not been seen yet is kept around, waiting for that parent
+	// to arrive either as a later announcement or through the forwarder
+	// catching up.
+	defaultOrphanTTL = 2 * time.Minute
+
+	// defaultYieldLagThreshold is the default number of blocks the
+	// forwarder is allowed to lag behind the Bitcoin chain before the
+	// fetcher stops forwarding announcements and leaves catching up to the
+	// forwarder's own skeleton sync.
+	defaultYieldLagThreshold = skeletonSyncLagThreshold
+
+	// orphanSweepInterval is how often expired orphans are purged.
+	orphanSweepInterval = 30 * time.Second
+)
+
+var fetcherLogger = log.Logger("relay-block-fetcher")
+
+// fetcherConfig holds the configurable parameters of a Fetcher, applied
+// through FetcherOption functions before the Fetcher is constructed.
+type fetcherConfig struct {
+	orphanTTL         time.Duration
+	yieldLagThreshold int64
+}
+
+// FetcherOption configures optional parameters of the Fetcher.
+type FetcherOption func(*fetcherConfig)
+
+// WithOrphanTTL overrides the duration an announced header whose parent has
+// not been seen yet is kept around waiting for that parent to arrive.
+func WithOrphanTTL(ttl time.Duration) FetcherOption {
+	return func(c *fetcherConfig) {
+		c.orphanTTL = ttl
+	}
+}
+
+// WithYieldLagThreshold overrides the number of blocks the forwarder is
+// allowed to lag behind the Bitcoin chain before the fetcher stops
+// forwarding announcements and yields to the forwarder's own sync.
+func WithYieldLagThreshold(threshold int64) FetcherOption {
+	return func(c *fetcherConfig) {
+		c.yieldLagThreshold = threshold
+	}
+}
+
+// orphanHeader is an announced header that could not yet be chained onto the
+// host chain's current tip because its parent has not been seen yet.
+type orphanHeader struct {
+	header    *btc.Header
+	expiresAt time.Time
+}
+
+// Fetcher subscribes to newly announced Bitcoin tip headers and forwards
+// them to the host chain as soon as they arrive, bypassing the bulk
+// Forwarder's sleep cycle. It exists to give the host chain low-latency
+// visibility of the Bitcoin tip without forcing the bulk Forwarder to pull
+// and push on every single new block; the Forwarder remains the source of
+// truth for catching up after an outage or a deep reorg.
+type Fetcher struct {
+	btcChain  btc.Handle
+	hostChain chain.Handle
+	forwarder *Forwarder
+
+	orphanTTL         time.Duration
+	yieldLagThreshold int64
+
+	errChan chan error
+
+	orphansMutex sync.Mutex
+	// orphans holds announced headers whose parent is not yet part of the
+	// host chain, keyed by that parent's hash.
+	orphans map[[32]byte]*orphanHeader
+}
+
+// RunFetcher creates an instance of the block fetcher and runs its
+// processing loop. The lifecycle of the fetcher can be managed using the
+// passed context. forwarder is consulted to avoid forwarding announcements
+// for headers the bulk Forwarder is already about to push, and to learn
+// when the Forwarder is lagging far enough behind that the fetcher should
+// yield to it entirely.
+func RunFetcher(
+	ctx context.Context,
+	btcChain btc.Handle,
+	hostChain chain.Handle,
+	forwarder *Forwarder,
+	opts ...FetcherOption,
+) *Fetcher {
+	config := &fetcherConfig{
+		orphanTTL:         defaultOrphanTTL,
+		yieldLagThreshold: defaultYieldLagThreshold,
+	}
+
+	for _, opt := range opts {
+		opt(config)
+	}
+
+	fetcher := &Fetcher{
+		btcChain:          btcChain,
+		hostChain:         hostChain,
+		forwarder:         forwarder,
+		orphanTTL:         config.orphanTTL,
+		yieldLagThreshold: config.yieldLagThreshold,
+		errChan:           make(chan error, 1),
+		orphans:           make(map[[32]byte]*orphanHeader),
+	}
+
+	go fetcher.loop(ctx)
+
+	return fetcher
+}
+
+// loop subscribes to new head announcements and processes them until ctx is
+// done.
+func (f *Fetcher) loop(ctx context.Context) {
+	fetcherLogger.Infof("running fetcher")
+
+	announcements := make(chan *btc.Header, announcementsChannelSize)
+	unsubscribe := f.btcChain.SubscribeNewHead(announcements)
+	defer unsubscribe()
+
+	sweepTicker := time.NewTicker(orphanSweepInterval)
+	defer sweepTicker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			fetcherLogger.Infof("fetcher context is done")
+			return
+		case header := <-announcements:
+			f.handleAnnouncement(header)
+		case <-sweepTicker.C:
+			f.sweepExpiredOrphans()
+		}
+	}
+}
+
+// handleAnnouncement processes a single announced header, forwarding it to
+// the host chain if possible, queuing it as an orphan if its parent has not
+// been seen yet, or dropping it if it is redundant with the bulk Forwarder's
+// own progress.
+func (f *Fetcher) handleAnnouncement(header *btc.Header) {
+	fetcherAnnouncementsTotal.Inc()
+
+	if f.forwarder.IsInFlight(header) {
+		fetcherLogger.Debugf(
+			"dropping announcement for header at height [%v]: "+
+				"already in flight on the forwarder",
+			header.Height,
+		)
+		return
+	}
+
+	if lag, err := f.forwarder.Lag(); err == nil && lag > f.yieldLagThreshold {
+		fetcherYieldedTotal.Inc()
+		fetcherLogger.Debugf(
+			"yielding announcement for header at height [%v]: "+
+				"forwarder lag [%v] exceeds threshold [%v]",
+			header.Height,
+			lag,
+			f.yieldLagThreshold,
+		)
+		return
+	}
+
+	f.forward(header)
+}
+
+// forward attempts to chain header onto the host chain's current tip,
+// pushing it immediately on success and recursively resolving any orphans
+// that were waiting on it. If header cannot be chained yet, it is queued as
+// an orphan awaiting its parent.
+func (f *Fetcher) forward(header *btc.Header) {
+	tipDigest, err := f.hostChain.GetBestKnownDigest()
+	if err != nil {
+		f.reportError(fmt.Errorf(
+			"could not get best known digest: [%v]",
+			err,
+		))
+		return
+	}
+
+	if header.PrevHash != tipDigest {
+		f.queueOrphan(header)
+		return
+	}
+
+	if err := f.pushHeader(header); err != nil {
+		fetcherLogger.Warningf(
+			"could not forward header at height [%v]: [%v]",
+			header.Height,
+			err,
+		)
+		return
+	}
+
+	fetcherForwardedTotal.Inc()
+
+	if child, ok := f.popOrphan(header.Hash); ok {
+		f.forward(child)
+	}
+}
+
+// pushHeader adds header to the host chain, proving the difficulty retarget
+// it introduces via AddHeadersWithRetarget instead of the plain AddHeaders
+// when header is the first header of a new Bitcoin difficulty epoch. The
+// Fetcher exists to beat the bulk Forwarder's own poll cycle, so an
+// epoch-boundary header is, if anything, more likely to arrive here first
+// than through the pulling loop's dedup, and must be routed the same way
+// pushHeadersToChain routes it for the bulk path.
+func (f *Fetcher) pushHeader(header *btc.Header) error {
+	if header.Height%difficultyEpochDuration != 0 {
+		return f.hostChain.AddHeaders(header.PrevHash, []*btc.Header{header})
+	}
+
+	oldPeriodEnd := &btc.Header{
+		Height: header.Height - 1,
+		Hash:   header.PrevHash,
+	}
+
+	return addHeadersWithRetarget(f.btcChain, f.hostChain, oldPeriodEnd, []*btc.Header{header})
+}
+
+// queueOrphan remembers header as waiting for its parent to become the host
+// chain's tip.
+func (f *Fetcher) queueOrphan(header *btc.Header) {
+	f.orphansMutex.Lock()
+	defer f.orphansMutex.Unlock()
+
+	f.orphans[header.PrevHash] = &orphanHeader{
+		header:    header,
+		expiresAt: time.Now().Add(f.orphanTTL),
+	}
+
+	fetcherOrphansTotal.Inc()
+}
+
+// popOrphan returns and removes the orphan waiting on parentHash, if any.
+func (f *Fetcher) popOrphan(parentHash [32]byte) (*btc.Header, bool) {
+	f.orphansMutex.Lock()
+	defer f.orphansMutex.Unlock()
+
+	orphan, ok := f.orphans[parentHash]
+	if !ok {
+		return nil, false
+	}
+
+	delete(f.orphans, parentHash)
+	return orphan.header, true
+}
+
+// sweepExpiredOrphans discards orphans that have been waiting for their
+// parent for longer than orphanTTL.
+func (f *Fetcher) sweepExpiredOrphans() {
+	f.orphansMutex.Lock()
+	defer f.orphansMutex.Unlock()
+
+	now := time.Now()
+	for parentHash, orphan := range f.orphans {
+		if now.After(orphan.expiresAt) {
+			delete(f.orphans, parentHash)
+		}
+	}
+}
+
+// ErrChan returns the error channel of the fetcher. It reports failures to
+// read the host chain's best known digest; these are not fatal to the
+// fetcher's own loop. A header that fails to be pushed is only logged and is
+// not reported here.
+func (f *Fetcher) ErrChan() <-chan error {
+	return f.errChan
+}
+
+// reportError surfaces a non-fatal error on errChan without blocking the
+// fetcher's own loop. errChan has room for a single pending error; if it is
+// still full because the previous one has not been drained yet, this error
+// is logged and dropped instead of stalling announcement handling, orphan
+// sweeping, and context-cancellation shutdown until some external consumer
+// reads ErrChan().
+func (f *Fetcher) reportError(err error) {
+	select {
+	case f.errChan <- err:
+	default:
+		fetcherErrorsDroppedTotal.Inc()
+		fetcherLogger.Warningf(
+			"dropping fetcher error because errChan is still full: [%v]",
+			err,
+		)
+	}
+}
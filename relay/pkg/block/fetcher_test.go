@@ -0,0 +1,270 @@
+package block
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/keep-network/tbtc/relay/pkg/btc"
+	btclocal "github.com/keep-network/tbtc/relay/pkg/btc/local"
+	"github.com/keep-network/tbtc/relay/pkg/chain"
+	chainlocal "github.com/keep-network/tbtc/relay/pkg/chain/local"
+)
+
+// alwaysErroringChain is a host chain handle whose GetBestKnownDigest call
+// always fails, used to drive the fetcher down its non-fatal error path
+// repeatedly without ever making progress.
+type alwaysErroringChain struct{}
+
+func (alwaysErroringChain) GetBestKnownDigest() ([32]byte, error) {
+	return [32]byte{}, fmt.Errorf("host chain unavailable")
+}
+
+func (alwaysErroringChain) AddHeaders([32]byte, []*btc.Header) error {
+	return fmt.Errorf("host chain unavailable")
+}
+
+func (alwaysErroringChain) AddHeadersWithRetarget(
+	[32]byte,
+	[32]byte,
+	[]*btc.Header,
+) error {
+	return fmt.Errorf("host chain unavailable")
+}
+
+var _ chain.Handle = alwaysErroringChain{}
+
+func TestFetcher_ForwardsAnnouncementImmediately(t *testing.T) {
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	defer cancelCtx()
+
+	bc, err := btclocal.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	btcChain := bc.(*btclocal.Chain)
+
+	genesis := headerAtHeight(0)
+	btcChain.SetHeaders([]*btc.Header{genesis})
+
+	hc, err := chainlocal.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostChain := hc.(*chainlocal.Chain)
+	hostChain.SeedHeader(genesis)
+	hostChain.SetBestKnownDigest(genesis.Hash)
+
+	forwarder := RunForwarder(ctx, []btc.Handle{btcChain}, hostChain)
+	RunFetcher(ctx, btcChain, hostChain, forwarder)
+
+	// Give the forwarder's pulling loop a moment to observe the genesis tip
+	// before the announcement arrives, so it does not race the fetcher.
+	time.Sleep(50 * time.Millisecond)
+
+	head := headerAtHeight(1)
+	btcChain.AnnounceHead(head)
+
+	// The bulk forwarder cannot push a partial batch before headerTimeout
+	// elapses; if the header reaches the host chain well before that, it
+	// must have gone through the fetcher's low-latency path instead.
+	deadline := time.Now().Add(headerTimeout / 2)
+	for {
+		digest, err := hostChain.GetBestKnownDigest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if digest == head.Hash {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("announced header was not forwarded to the host chain in time")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestFetcher_ForwardsEpochBoundaryHeaderWithRetarget checks that an
+// announced header opening a new Bitcoin difficulty epoch is routed through
+// AddHeadersWithRetarget, the same way the bulk Forwarder's
+// pushHeadersToChain routes it, instead of the plain AddHeaders.
+func TestFetcher_ForwardsEpochBoundaryHeaderWithRetarget(t *testing.T) {
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	defer cancelCtx()
+
+	bc, err := btclocal.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	btcChain := bc.(*btclocal.Chain)
+
+	genesis := headerAtHeight(0)
+	oldPeriodEnd := headerAtHeight(difficultyEpochDuration - 1)
+	btcChain.SetHeaders([]*btc.Header{genesis, oldPeriodEnd})
+
+	hc, err := chainlocal.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostChain := hc.(*chainlocal.Chain)
+	hostChain.SeedHeader(genesis)
+	hostChain.SeedHeader(oldPeriodEnd)
+	hostChain.SetBestKnownDigest(oldPeriodEnd.Hash)
+
+	forwarder := RunForwarder(ctx, []btc.Handle{btcChain}, hostChain)
+	RunFetcher(ctx, btcChain, hostChain, forwarder)
+
+	time.Sleep(50 * time.Millisecond)
+
+	boundaryHeader := headerAtHeight(difficultyEpochDuration)
+	btcChain.AnnounceHead(boundaryHeader)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		digest, err := hostChain.GetBestKnownDigest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if digest == boundaryHeader.Hash {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("epoch boundary announcement was not forwarded to the host chain in time")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestFetcher_QueuesOrphanUntilParentArrives(t *testing.T) {
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	defer cancelCtx()
+
+	bc, err := btclocal.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	btcChain := bc.(*btclocal.Chain)
+
+	genesis := headerAtHeight(0)
+	btcChain.SetHeaders([]*btc.Header{genesis})
+
+	hc, err := chainlocal.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostChain := hc.(*chainlocal.Chain)
+	hostChain.SeedHeader(genesis)
+	hostChain.SetBestKnownDigest(genesis.Hash)
+
+	forwarder := RunForwarder(ctx, []btc.Handle{btcChain}, hostChain)
+	RunFetcher(ctx, btcChain, hostChain, forwarder)
+
+	time.Sleep(50 * time.Millisecond)
+
+	// Announce height 2 before height 1 has been seen; it cannot chain from
+	// the host chain's current tip yet and should be queued as an orphan.
+	headOne := headerAtHeight(1)
+	headTwo := headerAtHeight(2)
+	btcChain.AnnounceHead(headTwo)
+
+	time.Sleep(50 * time.Millisecond)
+
+	digest, err := hostChain.GetBestKnownDigest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if digest != genesis.Hash {
+		t.Fatalf(
+			"expected orphaned announcement to not advance the host chain tip; "+
+				"got digest [%x]",
+			digest,
+		)
+	}
+
+	// Now the missing parent arrives; the fetcher should forward it and
+	// then resolve the previously queued orphan.
+	btcChain.AnnounceHead(headOne)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		digest, err := hostChain.GetBestKnownDigest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if digest == headTwo.Hash {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("orphaned announcement was never resolved")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestFetcher_ErrChanFullDoesNotBlockLoop reproduces a fetcher whose host
+// chain errors on every announcement: without draining ErrChan(), the
+// second announcement used to block the loop goroutine trying to send on
+// the capacity-1 errChan, freezing announcement handling and
+// context-cancellation shutdown alike.
+func TestFetcher_ErrChanFullDoesNotBlockLoop(t *testing.T) {
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	defer cancelCtx()
+
+	bc, err := btclocal.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	btcChain := bc.(*btclocal.Chain)
+
+	genesis := headerAtHeight(0)
+	btcChain.SetHeaders([]*btc.Header{genesis})
+
+	forwarder := &Forwarder{
+		btcChain:      btcChain,
+		recentHeaders: make(map[int64]*btc.Header),
+	}
+
+	RunFetcher(ctx, btcChain, alwaysErroringChain{}, forwarder)
+
+	// Give the fetcher's loop a moment to subscribe before announcing, so
+	// announcements are not missed because no subscriber was registered yet.
+	time.Sleep(50 * time.Millisecond)
+
+	before := testutil.ToFloat64(fetcherAnnouncementsTotal)
+
+	// Announce three headers back to back, without ever draining ErrChan().
+	// If forward() blocked sending the second error, the loop goroutine
+	// would never reach the third announcement below.
+	btcChain.AnnounceHead(headerAtHeight(1))
+	btcChain.AnnounceHead(headerAtHeight(2))
+	btcChain.AnnounceHead(headerAtHeight(3))
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if testutil.ToFloat64(fetcherAnnouncementsTotal)-before >= 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal(
+				"not all announcements were handled; did the loop block " +
+					"sending on a full errChan?",
+			)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The loop must also still honor context cancellation promptly, rather
+	// than being stuck on a blocked errChan send.
+	cancelCtx()
+	time.Sleep(50 * time.Millisecond)
+
+	before = testutil.ToFloat64(fetcherAnnouncementsTotal)
+	btcChain.AnnounceHead(headerAtHeight(4))
+	time.Sleep(50 * time.Millisecond)
+	if testutil.ToFloat64(fetcherAnnouncementsTotal) != before {
+		t.Error("fetcher loop kept processing announcements after its context was cancelled")
+	}
+}
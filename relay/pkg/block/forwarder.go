@@ -2,9 +2,13 @@ package block
 
 import (
 	"context"
+	"fmt"
+	"sync"
 	"time"
 
 	"github.com/ipfs/go-log"
+	"github.com/prometheus/client_golang/prometheus"
+
 	"github.com/keep-network/tbtc/relay/pkg/btc"
 	"github.com/keep-network/tbtc/relay/pkg/chain"
 )
@@ -26,10 +30,74 @@ const (
 	// Duration for which the forwarder should rest after performing
 	// a push action.
 	forwarderSleepTime = 45 * time.Second
+
+	// Default maximum number of blocks the pulling loop will walk back
+	// while searching for a common ancestor after detecting that the
+	// previously known Bitcoin tip is no longer part of the main chain.
+	defaultMaxReorgDepth = 100
+
+	// skeletonSyncLagThreshold is the number of blocks the host chain must
+	// lag behind the Bitcoin chain before the pulling loop switches from
+	// FullSync to SkeletonSync.
+	skeletonSyncLagThreshold = 512
+
+	// skeletonSegmentSize is the distance, in blocks, between two
+	// consecutive skeleton anchors during SkeletonSync.
+	skeletonSegmentSize = 192
+
+	// defaultAnnounceQuorum is the default fraction of configured Bitcoin
+	// sources that must agree on a header before the forwarder trusts it.
+	defaultAnnounceQuorum = 0.66
+)
+
+// SyncMode describes how the pulling loop discovers and pulls new Bitcoin
+// headers.
+type SyncMode int
+
+const (
+	// FullSync pulls headers one by one, in order. It is used near the
+	// Bitcoin chain tip, where only a handful of headers are pulled at a
+	// time.
+	FullSync SyncMode = iota
+
+	// SkeletonSync pulls a sparse skeleton of headers first, then fills
+	// the gaps between skeleton anchors concurrently. It is used when the
+	// host chain lags far behind the Bitcoin chain, to close the gap with
+	// far fewer serial round-trips than FullSync.
+	SkeletonSync
 )
 
 var logger = log.Logger("relay-block-forwarder")
 
+// forwarderConfig holds the configurable parameters of a Forwarder, applied
+// through Option functions before the Forwarder is constructed.
+type forwarderConfig struct {
+	maxReorgDepth  int64
+	announceQuorum float64
+}
+
+// Option configures optional parameters of the Forwarder.
+type Option func(*forwarderConfig)
+
+// WithMaxReorgDepth overrides the maximum number of blocks the pulling loop
+// is allowed to walk back while searching for a common ancestor during
+// reorg recovery. If no common ancestor is found within this depth, the
+// forwarder reports a fatal error on its error channel.
+func WithMaxReorgDepth(maxReorgDepth int64) Option {
+	return func(c *forwarderConfig) {
+		c.maxReorgDepth = maxReorgDepth
+	}
+}
+
+// WithAnnounceQuorum overrides the fraction, between 0 (exclusive) and 1
+// (inclusive), of configured Bitcoin sources that must agree on a header
+// before the forwarder trusts it.
+func WithAnnounceQuorum(quorum float64) Option {
+	return func(c *forwarderConfig) {
+		c.announceQuorum = quorum
+	}
+}
+
 // Forwarder takes blocks from the Bitcoin chain and forwards them to the
 // given host chain.
 type Forwarder struct {
@@ -40,29 +108,495 @@ type Forwarder struct {
 
 	headersQueue chan *btc.Header
 	errChan      chan error
+
+	// maxReorgDepth is the maximum number of blocks the pulling loop will
+	// walk back while searching for a common ancestor during reorg
+	// recovery.
+	maxReorgDepth int64
+	// recentHeadersMutex guards recentHeaders, which is read by the Fetcher
+	// from its own goroutine in addition to the pulling loop.
+	recentHeadersMutex sync.RWMutex
+	// recentHeaders holds the headers pulled so far, keyed by height, up
+	// to maxReorgDepth behind the current tip. It lets the pulling loop
+	// detect reorgs and find the common ancestor without querying the
+	// host chain for historical state.
+	recentHeaders map[int64]*btc.Header
+
+	// syncMode is the pulling loop's current sync mode. It is only ever
+	// touched from the pulling loop goroutine; it is mirrored on the
+	// currentSyncMode gauge so operators and tests can observe it from
+	// outside the pulling loop.
+	syncMode SyncMode
 }
 
 // RunForwarder creates an instance of the block forwarder and runs its
 // processing loop. The lifecycle of the forwarder loop can be managed
-// using the passed context.
+// using the passed context. btcChains may hold more than one Bitcoin
+// source; headers are only trusted once the configured AnnounceQuorum
+// fraction of them agree, protecting the host chain from a single
+// compromised or forked source.
 func RunForwarder(
 	ctx context.Context,
-	btcChain btc.Handle,
+	btcChains []btc.Handle,
 	hostChain chain.Handle,
+	opts ...Option,
 ) *Forwarder {
+	config := &forwarderConfig{
+		maxReorgDepth:  defaultMaxReorgDepth,
+		announceQuorum: defaultAnnounceQuorum,
+	}
+
+	for _, opt := range opts {
+		opt(config)
+	}
+
+	if config.announceQuorum <= 0 || config.announceQuorum > 1 {
+		logger.Warningf(
+			"announce quorum [%v] is not between 0 (exclusive) and 1 "+
+				"(inclusive); falling back to default [%v]",
+			config.announceQuorum,
+			defaultAnnounceQuorum,
+		)
+		config.announceQuorum = defaultAnnounceQuorum
+	}
+
 	forwarder := &Forwarder{
-		btcChain:     btcChain,
-		hostChain:    hostChain,
-		headersQueue: make(chan *btc.Header, headersQueueSize),
-		errChan:      make(chan error, 1),
+		btcChain:      btc.NewMultiChain(btcChains, config.announceQuorum),
+		hostChain:     hostChain,
+		headersQueue:  make(chan *btc.Header, headersQueueSize),
+		errChan:       make(chan error, 1),
+		maxReorgDepth: config.maxReorgDepth,
+		recentHeaders: make(map[int64]*btc.Header),
 	}
 
-	go forwarder.loop(ctx)
+	go forwarder.pullingLoop(ctx)
+	go forwarder.pushingLoop(ctx)
 
 	return forwarder
 }
 
-func (f *Forwarder) loop(ctx context.Context) {
+// pullingLoop continuously discovers new Bitcoin headers and places them
+// on the headers queue for the pushing loop to consume. It is also
+// responsible for detecting Bitcoin chain reorgs and recovering from them
+// by rewinding to the common ancestor before resuming.
+func (f *Forwarder) pullingLoop(ctx context.Context) {
+	logger.Infof("running pulling loop")
+
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Infof("pulling loop context is done")
+			return
+		default:
+			tip, err := f.resolveTip()
+			if err != nil {
+				errorsTotal.WithLabelValues("pull").Inc()
+				f.errChan <- fmt.Errorf(
+					"could not find best block for pulling loop: [%v]",
+					err,
+				)
+				return
+			}
+
+			btcBestHeight, err := f.btcChain.GetBestHeight()
+			if err != nil {
+				errorsTotal.WithLabelValues("pull").Inc()
+				f.errChan <- fmt.Errorf(
+					"could not get best height for pulling loop: [%v]",
+					err,
+				)
+				return
+			}
+
+			if tip.Height >= btcBestHeight {
+				logger.Debugf("no new headers to pull; waiting")
+
+				select {
+				case <-time.After(headerTimeout):
+				case <-ctx.Done():
+					return
+				}
+				continue
+			}
+
+			if lag := btcBestHeight - tip.Height; lag > skeletonSyncLagThreshold {
+				f.syncMode = SkeletonSync
+				currentSyncMode.Set(float64(SkeletonSync))
+
+				// Only sync up to the last full skeleton segment; the
+				// remaining, less-than-a-segment stretch up to
+				// btcBestHeight is left for FullSync once the lag has
+				// shrunk below the threshold.
+				segments := lag / skeletonSegmentSize
+				toHeight := tip.Height + segments*skeletonSegmentSize
+
+				if err := f.skeletonSync(ctx, tip.Height, toHeight); err != nil {
+					errorsTotal.WithLabelValues("pull").Inc()
+					f.errChan <- fmt.Errorf(
+						"could not perform skeleton sync: [%v]",
+						err,
+					)
+					return
+				}
+				continue
+			}
+
+			f.syncMode = FullSync
+			currentSyncMode.Set(float64(FullSync))
+
+			for height := tip.Height + 1; height <= btcBestHeight; height++ {
+				header, err := f.btcChain.GetHeaderByHeight(height)
+				if err != nil {
+					errorsTotal.WithLabelValues("pull").Inc()
+					f.errChan <- fmt.Errorf(
+						"could not pull header at height [%v]: [%v]",
+						height,
+						err,
+					)
+					return
+				}
+
+				if !f.enqueueHeader(ctx, header) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// enqueueHeader places header on the headers queue, remembering it for
+// reorg recovery and updating metrics. It returns false if the context was
+// cancelled before the header could be enqueued.
+func (f *Forwarder) enqueueHeader(ctx context.Context, header *btc.Header) bool {
+	select {
+	case f.headersQueue <- header:
+		f.rememberHeader(header)
+		headersPulledTotal.Inc()
+		headersQueueDepth.Set(float64(len(f.headersQueue)))
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// skeletonSync closes a large gap between the pulling loop's current tip
+// and toHeight by first fetching a sparse skeleton of anchors spaced
+// skeletonSegmentSize blocks apart, then filling the segments between
+// consecutive anchors concurrently. Filled segments are handed to the
+// headers queue in order, so the pushing loop still sees a contiguous
+// chain.
+func (f *Forwarder) skeletonSync(ctx context.Context, fromHeight, toHeight int64) error {
+	anchorHeights := make([]int64, 0)
+	for height := fromHeight; height < toHeight; height += skeletonSegmentSize {
+		anchorHeights = append(anchorHeights, height)
+	}
+	anchorHeights = append(anchorHeights, toHeight)
+
+	// Fetch and validate the skeleton anchors up front, so a gap in the
+	// Bitcoin chain is reported before any concurrent segment fetch is
+	// kicked off.
+	anchors := make([]*btc.Header, len(anchorHeights))
+	for i, height := range anchorHeights {
+		select {
+		case <-ctx.Done():
+			return nil
+		default:
+		}
+
+		anchor, err := f.btcChain.GetHeaderByHeight(height)
+		if err != nil {
+			return fmt.Errorf(
+				"could not get skeleton anchor at height [%v]: [%v]",
+				height,
+				err,
+			)
+		}
+		anchors[i] = anchor
+	}
+
+	segmentCount := len(anchors) - 1
+	segments := make([][]*btc.Header, segmentCount)
+	errs := make([]error, segmentCount)
+
+	var wg sync.WaitGroup
+	for i := 0; i < segmentCount; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			start := anchorHeights[i]
+			count := anchorHeights[i+1] - start + 1
+
+			headers, err := f.btcChain.GetHeadersByRange(start, count)
+			if err != nil {
+				errs[i] = fmt.Errorf(
+					"could not fill skeleton segment starting at "+
+						"height [%v]: [%v]",
+					start,
+					err,
+				)
+				return
+			}
+
+			segments[i] = headers
+		}(i)
+	}
+
+	segmentsDone := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(segmentsDone)
+	}()
+
+	select {
+	case <-segmentsDone:
+	case <-ctx.Done():
+		// Let the in-flight segment fetches finish in the background; their
+		// results are discarded since the pulling loop is shutting down.
+		return nil
+	}
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	for i, headers := range segments {
+		for j, header := range headers {
+			if j == 0 {
+				// The first header of a segment is the previous segment's
+				// closing anchor; it has already been validated and
+				// enqueued.
+				continue
+			}
+
+			if header.PrevHash != headers[j-1].Hash {
+				return fmt.Errorf(
+					"skeleton segment starting at height [%v] does not "+
+						"chain at height [%v]",
+					anchorHeights[i],
+					header.Height,
+				)
+			}
+
+			if !f.enqueueHeader(ctx, header) {
+				return nil
+			}
+		}
+	}
+
+	return nil
+}
+
+// resolveTip returns the header the pulling loop currently considers to be
+// the Bitcoin tip already reflected on the host chain. If no header has
+// been pulled yet, it is discovered from the host chain's best known
+// digest. If the previously pulled tip is no longer part of the Bitcoin
+// main chain, a reorg recovery bisection is run to find the common
+// ancestor.
+func (f *Forwarder) resolveTip() (*btc.Header, error) {
+	cached := f.bestRememberedHeader()
+	if cached == nil {
+		digest, err := f.hostChain.GetBestKnownDigest()
+		if err != nil {
+			return nil, fmt.Errorf(
+				"could not get best known digest: [%v]",
+				err,
+			)
+		}
+
+		header, err := f.btcChain.GetHeaderByDigest(digest)
+		if err != nil {
+			return nil, err
+		}
+
+		f.rememberHistory(header)
+		return header, nil
+	}
+
+	onChain, err := f.btcChain.GetHeaderByHeight(cached.Height)
+	if err == nil && onChain.Hash == cached.Hash {
+		return cached, nil
+	}
+
+	logger.Warningf(
+		"Bitcoin chain tip mismatch detected at height [%v]; "+
+			"starting reorg recovery",
+		cached.Height,
+	)
+
+	return f.recoverFromReorg(cached.Height)
+}
+
+// recoverFromReorg performs a binary search between knownHeight and
+// knownHeight - maxReorgDepth, comparing the remembered header at each
+// probed height against the Bitcoin chain's current header at that height,
+// until the common ancestor is found.
+func (f *Forwarder) recoverFromReorg(knownHeight int64) (*btc.Header, error) {
+	floor := knownHeight - f.maxReorgDepth
+	if floor < 0 {
+		floor = 0
+	}
+
+	low, high := floor, knownHeight
+	var ancestor *btc.Header
+
+	for low <= high {
+		mid := (low + high) / 2
+
+		onChainHeader, err := f.btcChain.GetHeaderByHeight(mid)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"could not get header at height [%v] during reorg "+
+					"recovery: [%v]",
+				mid,
+				err,
+			)
+		}
+
+		rememberedHeader := f.rememberedHeaderAt(mid)
+		if rememberedHeader != nil && rememberedHeader.Hash == onChainHeader.Hash {
+			ancestor = onChainHeader
+			low = mid + 1
+		} else {
+			high = mid - 1
+		}
+	}
+
+	if ancestor == nil {
+		errorsTotal.WithLabelValues("reorg").Inc()
+		return nil, fmt.Errorf(
+			"reorg exceeds maximum depth of [%v] blocks",
+			f.maxReorgDepth,
+		)
+	}
+
+	logger.Warningf(
+		"found common ancestor at height [%v] after reorg",
+		ancestor.Height,
+	)
+
+	reorgsTotal.Inc()
+	f.forgetHeadersAfter(ancestor.Height)
+
+	return ancestor, nil
+}
+
+// rememberHistory records header as the pulled tip and backfills
+// recentHeaders with its maxReorgDepth blocks of Bitcoin chain history.
+// Without this, a forwarder that starts out already caught up to the tip
+// would only ever remember that single header, so recoverFromReorg would
+// have nothing to compare below it and even a shallow reorg of the tip
+// would look like it exceeds maxReorgDepth.
+func (f *Forwarder) rememberHistory(header *btc.Header) {
+	f.rememberHeader(header)
+
+	floor := header.Height - f.maxReorgDepth
+	if floor < 0 {
+		floor = 0
+	}
+	if floor >= header.Height {
+		return
+	}
+
+	history, err := f.btcChain.GetHeadersByRange(floor, header.Height-floor)
+	if err != nil {
+		logger.Warningf(
+			"could not backfill reorg recovery history from height "+
+				"[%v]: [%v]",
+			floor,
+			err,
+		)
+		return
+	}
+
+	f.recentHeadersMutex.Lock()
+	defer f.recentHeadersMutex.Unlock()
+	for _, historyHeader := range history {
+		f.recentHeaders[historyHeader.Height] = historyHeader
+	}
+}
+
+// rememberHeader records the given header as pulled, pruning remembered
+// headers that are now further than maxReorgDepth behind it.
+func (f *Forwarder) rememberHeader(header *btc.Header) {
+	f.recentHeadersMutex.Lock()
+	defer f.recentHeadersMutex.Unlock()
+
+	f.recentHeaders[header.Height] = header
+
+	floor := header.Height - f.maxReorgDepth
+	for height := range f.recentHeaders {
+		if height < floor {
+			delete(f.recentHeaders, height)
+		}
+	}
+}
+
+// rememberedHeaderAt returns the remembered header at the given height, or
+// nil if no header has been remembered there.
+func (f *Forwarder) rememberedHeaderAt(height int64) *btc.Header {
+	f.recentHeadersMutex.RLock()
+	defer f.recentHeadersMutex.RUnlock()
+
+	return f.recentHeaders[height]
+}
+
+// bestRememberedHeader returns the highest-height header pulled so far, or
+// nil if no header has been pulled yet.
+func (f *Forwarder) bestRememberedHeader() *btc.Header {
+	f.recentHeadersMutex.RLock()
+	defer f.recentHeadersMutex.RUnlock()
+
+	var best *btc.Header
+	for _, header := range f.recentHeaders {
+		if best == nil || header.Height > best.Height {
+			best = header
+		}
+	}
+	return best
+}
+
+// forgetHeadersAfter discards remembered headers above the given height.
+// It is used to rewind the pulling loop's tracked tip after a reorg.
+func (f *Forwarder) forgetHeadersAfter(height int64) {
+	f.recentHeadersMutex.Lock()
+	defer f.recentHeadersMutex.Unlock()
+
+	for h := range f.recentHeaders {
+		if h > height {
+			delete(f.recentHeaders, h)
+		}
+	}
+}
+
+// IsInFlight reports whether header has already been pulled by the bulk
+// forwarding loop, so the Fetcher can avoid forwarding a duplicate
+// announcement for a header the Forwarder is already about to push.
+func (f *Forwarder) IsInFlight(header *btc.Header) bool {
+	remembered := f.rememberedHeaderAt(header.Height)
+	return remembered != nil && remembered.Hash == header.Hash
+}
+
+// Lag returns the number of blocks the pulling loop's current tip is behind
+// the Bitcoin chain's best height, so the Fetcher can yield near-head
+// announcements to the bulk forwarding loop while it lags too far behind.
+func (f *Forwarder) Lag() (int64, error) {
+	tip := f.bestRememberedHeader()
+	if tip == nil {
+		return 0, fmt.Errorf("no header pulled yet")
+	}
+
+	btcBestHeight, err := f.btcChain.GetBestHeight()
+	if err != nil {
+		return 0, fmt.Errorf("could not get best height: [%v]", err)
+	}
+
+	return btcBestHeight - tip.Height, nil
+}
+
+func (f *Forwarder) pushingLoop(ctx context.Context) {
 	logger.Infof("running forwarder")
 
 	for {
@@ -116,6 +650,7 @@ func (f *Forwarder) pullHeadersFromQueue(ctx context.Context) []*btc.Header {
 			logger.Debugf("got header (%v) from queue", header.Height)
 
 			headers = append(headers, header)
+			headersQueueDepth.Set(float64(len(f.headersQueue)))
 
 			// Stop the timer. In case it already expired, drain the channel
 			// before performing reset.
@@ -155,17 +690,54 @@ func (f *Forwarder) pushHeadersToChain(headers []*btc.Header) {
 	startDifficulty := headers[0].Height % difficultyEpochDuration
 	endDifficulty := headers[len(headers)-1].Height % difficultyEpochDuration
 
+	pushTimer := prometheus.NewTimer(pushLatencySeconds)
+	defer pushTimer.ObserveDuration()
+
+	var err error
 	if startDifficulty == 0 {
-		// we have a difficulty change first
-		// TODO: implementation
+		// The whole batch opens a new difficulty epoch; prove the retarget
+		// against the previous epoch's last header, which is the anchor
+		// this batch chains from.
+		oldPeriodEnd := &btc.Header{
+			Height: headers[0].Height - 1,
+			Hash:   headers[0].PrevHash,
+		}
+		err = f.pushRetarget(oldPeriodEnd, headers)
 	} else if startDifficulty > endDifficulty {
-		// we span a difficulty change
-		// TODO: implementation
+		// The batch spans a difficulty change: finish the old epoch first,
+		// then prove the retarget before pushing the rest of the new one.
+		boundary := difficultyEpochDuration - startDifficulty
+		oldEpochHeaders := headers[:boundary]
+		newEpochHeaders := headers[boundary:]
+
+		if addErr := f.hostChain.AddHeaders(
+			oldEpochHeaders[0].PrevHash,
+			oldEpochHeaders,
+		); addErr != nil {
+			err = fmt.Errorf("could not add headers: [%v]", addErr)
+		} else {
+			oldPeriodEnd := oldEpochHeaders[len(oldEpochHeaders)-1]
+			err = f.pushRetarget(oldPeriodEnd, newEpochHeaders)
+		}
 	} else {
-		// no difficulty change
-		// TODO: implementation
+		// No difficulty change within the batch; push it as a plain
+		// continuation of the current epoch.
+		if addErr := f.hostChain.AddHeaders(
+			headers[0].PrevHash,
+			headers,
+		); addErr != nil {
+			err = fmt.Errorf("could not add headers: [%v]", addErr)
+		}
 	}
 
+	if err != nil {
+		errorsTotal.WithLabelValues("push").Inc()
+		f.errChan <- fmt.Errorf("could not push headers: [%v]", err)
+		return
+	}
+
+	headersPushedTotal.Add(float64(len(headers)))
+
 	f.processedHeaders += len(headers)
 	if f.processedHeaders >= headersBatchSize {
 		newBestHeader := headers[len(headers)-1]
@@ -174,8 +746,55 @@ func (f *Forwarder) pushHeadersToChain(headers []*btc.Header) {
 	}
 }
 
+// pushRetarget proves the difficulty retarget introduced by newEpochHeaders,
+// using the last header of the preceding difficulty epoch, and pushes
+// newEpochHeaders once the retarget is accepted.
+func (f *Forwarder) pushRetarget(
+	oldPeriodEnd *btc.Header,
+	newEpochHeaders []*btc.Header,
+) error {
+	return addHeadersWithRetarget(f.btcChain, f.hostChain, oldPeriodEnd, newEpochHeaders)
+}
+
+// addHeadersWithRetarget proves the difficulty retarget introduced by
+// newEpochHeaders, using the last header of the preceding difficulty epoch,
+// and pushes newEpochHeaders to hostChain once the retarget is accepted.
+// btcChain is consulted for the preceding epoch's start header. It is shared
+// by the Forwarder's bulk push path and the Fetcher's low-latency path, so
+// both route an epoch-boundary header through AddHeadersWithRetarget the
+// same way.
+func addHeadersWithRetarget(
+	btcChain btc.Handle,
+	hostChain chain.Handle,
+	oldPeriodEnd *btc.Header,
+	newEpochHeaders []*btc.Header,
+) error {
+	oldPeriodStartHeight := (oldPeriodEnd.Height / difficultyEpochDuration) *
+		difficultyEpochDuration
+
+	oldPeriodStart, err := btcChain.GetHeaderByHeight(oldPeriodStartHeight)
+	if err != nil {
+		return fmt.Errorf(
+			"could not get old difficulty period start header: [%v]",
+			err,
+		)
+	}
+
+	if err := hostChain.AddHeadersWithRetarget(
+		oldPeriodStart.Hash,
+		oldPeriodEnd.Hash,
+		newEpochHeaders,
+	); err != nil {
+		return fmt.Errorf("could not add headers with retarget: [%v]", err)
+	}
+
+	retargetsTotal.Inc()
+
+	return nil
+}
+
 func (f *Forwarder) updateBestHeader(header *btc.Header) {
-	// TODO: implementation
+	bestHeight.Set(float64(header.Height))
 }
 
 // ErrChan returns the error channel of the forwarder. Once an error
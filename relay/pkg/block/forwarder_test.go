@@ -2,16 +2,45 @@ package block
 
 import (
 	"context"
+	"encoding/binary"
 	"fmt"
 	"reflect"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
 	"github.com/keep-network/tbtc/relay/pkg/btc"
 	btclocal "github.com/keep-network/tbtc/relay/pkg/btc/local"
 	chainlocal "github.com/keep-network/tbtc/relay/pkg/chain/local"
 )
 
+// headerAtHeight builds a header whose hash uniquely encodes its own height
+// and whose PrevHash uniquely encodes the previous height, so that a run of
+// headers built this way always forms a valid PrevHash chain.
+func headerAtHeight(height int64) *btc.Header {
+	return &btc.Header{
+		Height:   height,
+		Hash:     hashOfHeight(height),
+		PrevHash: hashOfHeight(height - 1),
+	}
+}
+
+func hashOfHeight(height int64) [32]byte {
+	var hash [32]byte
+	binary.BigEndian.PutUint64(hash[24:], uint64(height))
+	return hash
+}
+
+func headerRange(from, to int64) []*btc.Header {
+	headers := make([]*btc.Header, 0, to-from+1)
+	for height := from; height <= to; height++ {
+		headers = append(headers, headerAtHeight(height))
+	}
+	return headers
+}
+
 func TestForwarder_PullingLoop_ContextCancellationShutdown(t *testing.T) {
 	ctx, cancelCtx := context.WithCancel(context.Background())
 	defer cancelCtx()
@@ -29,7 +58,7 @@ func TestForwarder_PullingLoop_ContextCancellationShutdown(t *testing.T) {
 
 	// Run forwarder with an empty Bitcoin chain and wait for a moment so
 	// the pulling loop goes to sleep
-	forwarder := RunForwarder(ctx, btcChain, localChain)
+	forwarder := RunForwarder(ctx, []btc.Handle{btcChain}, localChain)
 	time.Sleep(100 * time.Millisecond)
 
 	// While the pulling loop is sleeping, add headers to Bitcoin chain and
@@ -81,7 +110,7 @@ func TestForwarder_PullingLoop_ErrorShutdown(t *testing.T) {
 
 	localChain.SetBestKnownDigest([32]byte{2})
 
-	forwarder := RunForwarder(ctx, btcChain, localChain)
+	forwarder := RunForwarder(ctx, []btc.Handle{btcChain}, localChain)
 
 	select {
 	case err = <-forwarder.ErrChan():
@@ -121,6 +150,55 @@ func TestForwarder_PullingLoop_ErrorShutdown(t *testing.T) {
 	}
 }
 
+// TestForwarder_RunForwarder_InvalidAnnounceQuorumFallsBackToDefault
+// reproduces a quorum fraction outside (0, 1] reaching MultiChain unclamped:
+// a quorum of 2 makes requiredAgreement exceed the number of configured
+// sources, so even full agreement across all of them would never be
+// trusted. RunForwarder should fall back to the default quorum instead of
+// passing the invalid value through.
+func TestForwarder_RunForwarder_InvalidAnnounceQuorumFallsBackToDefault(t *testing.T) {
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	defer cancelCtx()
+
+	newAgreeingSource := func() btc.Handle {
+		bc, err := btclocal.Connect()
+		if err != nil {
+			t.Fatal(err)
+		}
+		source := bc.(*btclocal.Chain)
+		source.SetHeaders(headerRange(0, 1))
+		return source
+	}
+
+	sources := []btc.Handle{newAgreeingSource(), newAgreeingSource(), newAgreeingSource()}
+
+	lc, err := chainlocal.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	localChain := lc.(*chainlocal.Chain)
+	localChain.SeedHeader(headerAtHeight(0))
+	localChain.SetBestKnownDigest(hashOfHeight(0))
+
+	forwarder := RunForwarder(ctx, sources, localChain, WithAnnounceQuorum(2))
+
+	deadline := time.After(5 * time.Second)
+	select {
+	case header := <-forwarder.headersQueue:
+		if header.Height != 1 {
+			t.Errorf("expected header at height 1, got height [%v]", header.Height)
+		}
+	case err := <-forwarder.ErrChan():
+		t.Fatalf(
+			"forwarder reported an unexpected fatal error, did the invalid "+
+				"quorum reach MultiChain unclamped: %v",
+			err,
+		)
+	case <-deadline:
+		t.Fatal("test timeout has been exceeded")
+	}
+}
+
 func TestForwarder_PushingLoop_ContextCancellationShutdown(t *testing.T) {
 	ctx, cancelCtx := context.WithCancel(context.Background())
 	defer cancelCtx()
@@ -135,7 +213,7 @@ func TestForwarder_PushingLoop_ContextCancellationShutdown(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	forwarder := RunForwarder(ctx, btcChain, localChain)
+	forwarder := RunForwarder(ctx, []btc.Handle{btcChain}, localChain)
 
 	// Shutdown the pushing loop.
 	cancelCtx()
@@ -164,6 +242,486 @@ func TestForwarder_PushingLoop_ContextCancellationShutdown(t *testing.T) {
 	}
 }
 
+func TestForwarder_PushHeadersToChain_DifficultyTransitions(t *testing.T) {
+	tests := map[string]struct {
+		headers []*btc.Header
+		// seedEpochs lists the difficulty epoch start heights whose
+		// boundary headers (first and last) must already be known, in
+		// addition to the header right before the batch.
+		seedEpochs []int64
+	}{
+		"batch entirely within one epoch": {
+			headers: headerRange(100, 104),
+		},
+		"batch starting exactly on a retarget": {
+			headers:    headerRange(2016, 2020),
+			seedEpochs: []int64{0},
+		},
+		"batch straddling a retarget": {
+			headers:    headerRange(2013, 2017),
+			seedEpochs: []int64{0},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			bc, err := btclocal.Connect()
+			if err != nil {
+				t.Fatal(err)
+			}
+			btcChain := bc.(*btclocal.Chain)
+
+			lc, err := chainlocal.Connect()
+			if err != nil {
+				t.Fatal(err)
+			}
+			localChain := lc.(*chainlocal.Chain)
+
+			// Seed the header right before the batch, so the batch has an
+			// anchor to chain from, and make it the chain's current tip so
+			// AddHeaders accepts it as the anchor.
+			anchor := headerAtHeight(test.headers[0].Height - 1)
+			localChain.SeedHeader(anchor)
+			localChain.SetBestKnownDigest(anchor.Hash)
+
+			// Seed the boundaries of any difficulty epoch a retarget proof
+			// will need to reference on the host chain, and the epoch's first
+			// header, which the retarget proof looks up, on the Bitcoin chain.
+			for _, epochStart := range test.seedEpochs {
+				start := headerAtHeight(epochStart)
+				end := headerAtHeight(epochStart + difficultyEpochDuration - 1)
+
+				localChain.SeedHeader(start)
+				localChain.SeedHeader(end)
+				btcChain.SetHeaders([]*btc.Header{start})
+			}
+
+			forwarder := &Forwarder{
+				btcChain:      btcChain,
+				hostChain:     localChain,
+				headersQueue:  make(chan *btc.Header, headersQueueSize),
+				errChan:       make(chan error, 1),
+				maxReorgDepth: defaultMaxReorgDepth,
+				recentHeaders: make(map[int64]*btc.Header),
+			}
+
+			forwarder.pushHeadersToChain(test.headers)
+
+			select {
+			case err := <-forwarder.errChan:
+				t.Fatalf("unexpected error pushing headers: %v", err)
+			default:
+			}
+
+			expectedDigest := test.headers[len(test.headers)-1].Hash
+			actualDigest, err := localChain.GetBestKnownDigest()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expectedDigest != actualDigest {
+				t.Errorf(
+					"unexpected best known digest:\n"+
+						"expected: [%x]\n"+
+						"actual:   [%x]\n",
+					expectedDigest,
+					actualDigest,
+				)
+			}
+		})
+	}
+}
+
+func TestForwarder_Metrics(t *testing.T) {
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	defer cancelCtx()
+
+	pulledBefore := testutil.ToFloat64(headersPulledTotal)
+	pushedBefore := testutil.ToFloat64(headersPushedTotal)
+
+	bc, err := btclocal.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	btcChain := bc.(*btclocal.Chain)
+	btcChain.SetHeaders(headerRange(0, 12))
+
+	lc, err := chainlocal.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	localChain := lc.(*chainlocal.Chain)
+	localChain.SeedHeader(headerAtHeight(0))
+	localChain.SetBestKnownDigest(hashOfHeight(0))
+
+	RunForwarder(ctx, []btc.Handle{btcChain}, localChain)
+
+	// Give the pulling loop enough time to pull all 12 headers and the
+	// pushing loop enough time to push the first batch of 5.
+	time.Sleep(300 * time.Millisecond)
+
+	pulledAfter := testutil.ToFloat64(headersPulledTotal)
+	if pulledAfter <= pulledBefore {
+		t.Errorf(
+			"expected headersPulledTotal to increase, was [%v] now [%v]",
+			pulledBefore,
+			pulledAfter,
+		)
+	}
+
+	pushedAfter := testutil.ToFloat64(headersPushedTotal)
+	if pushedAfter <= pushedBefore {
+		t.Errorf(
+			"expected headersPushedTotal to increase, was [%v] now [%v]",
+			pushedBefore,
+			pushedAfter,
+		)
+	}
+}
+
+// countingBtcHandle wraps a btc.Handle and counts how many times its
+// per-height and per-range lookups are called, so tests can assert on the
+// number of round-trips a sync strategy needed.
+type countingBtcHandle struct {
+	btc.Handle
+
+	mutex                  sync.Mutex
+	getHeaderByHeightCalls int
+	getHeadersByRangeCalls int
+}
+
+func (c *countingBtcHandle) GetHeaderByHeight(height int64) (*btc.Header, error) {
+	c.mutex.Lock()
+	c.getHeaderByHeightCalls++
+	c.mutex.Unlock()
+
+	return c.Handle.GetHeaderByHeight(height)
+}
+
+func (c *countingBtcHandle) GetHeadersByRange(
+	startHeight int64,
+	count int64,
+) ([]*btc.Header, error) {
+	c.mutex.Lock()
+	c.getHeadersByRangeCalls++
+	c.mutex.Unlock()
+
+	return c.Handle.GetHeadersByRange(startHeight, count)
+}
+
+// blockingBtcHandle wraps a btc.Handle and blocks every GetHeadersByRange
+// call until unblock is closed, regardless of the caller's own context, so
+// tests can verify that callers depending on it react to context
+// cancellation instead of waiting for it to return.
+type blockingBtcHandle struct {
+	btc.Handle
+
+	unblock chan struct{}
+}
+
+func (b *blockingBtcHandle) GetHeadersByRange(
+	startHeight int64,
+	count int64,
+) ([]*btc.Header, error) {
+	<-b.unblock
+	return b.Handle.GetHeadersByRange(startHeight, count)
+}
+
+func TestForwarder_PullingLoop_SkeletonSync(t *testing.T) {
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	defer cancelCtx()
+
+	const gapSize = 2000
+
+	bc, err := btclocal.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	btcChain := bc.(*btclocal.Chain)
+	btcChain.SetHeaders(headerRange(0, gapSize))
+
+	counting := &countingBtcHandle{Handle: btcChain}
+
+	lc, err := chainlocal.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	localChain := lc.(*chainlocal.Chain)
+	localChain.SeedHeader(headerAtHeight(0))
+	localChain.SetBestKnownDigest(hashOfHeight(0))
+
+	forwarder := &Forwarder{
+		btcChain:      counting,
+		hostChain:     localChain,
+		headersQueue:  make(chan *btc.Header, headersQueueSize),
+		errChan:       make(chan error, 1),
+		maxReorgDepth: defaultMaxReorgDepth,
+		recentHeaders: make(map[int64]*btc.Header),
+	}
+
+	// Only run the pulling loop: draining the queue ourselves lets us
+	// observe every header it produces, instead of racing the pushing
+	// loop for them.
+	go forwarder.pullingLoop(ctx)
+
+	deadline := time.After(5 * time.Second)
+	reachedTip := false
+	for !reachedTip {
+		select {
+		case header := <-forwarder.headersQueue:
+			if header.Height == gapSize {
+				reachedTip = true
+			}
+		case err := <-forwarder.ErrChan():
+			t.Fatalf("forwarder reported an unexpected fatal error: %v", err)
+		case <-deadline:
+			t.Fatal("test timeout has been exceeded")
+		}
+	}
+
+	counting.mutex.Lock()
+	serialCalls := counting.getHeaderByHeightCalls
+	rangeCalls := counting.getHeadersByRangeCalls
+	counting.mutex.Unlock()
+
+	if rangeCalls == 0 {
+		t.Error("expected skeleton sync to fetch segments via GetHeadersByRange")
+	}
+
+	// FullSync would need one GetHeaderByHeight call per header; closing a
+	// 2000-block gap this way should need far fewer serial round-trips.
+	if serialCalls >= gapSize/2 {
+		t.Errorf(
+			"expected far fewer than [%v] serial GetHeaderByHeight calls "+
+				"to close a [%v]-block gap, got [%v]",
+			gapSize/2,
+			gapSize,
+			serialCalls,
+		)
+	}
+
+	// The gap narrows to less than a full skeleton segment before reaching
+	// the tip, so the pulling loop falls back to FullSync for the last
+	// stretch; the sync mode gauge should reflect that.
+	if mode := testutil.ToFloat64(currentSyncMode); mode != float64(FullSync) {
+		t.Errorf(
+			"expected sync mode gauge to report FullSync ([%v]) after "+
+				"reaching the tip, got [%v]",
+			float64(FullSync),
+			mode,
+		)
+	}
+}
+
+// TestForwarder_SkeletonSync_ContextCancellation reproduces a skeleton sync
+// whose segment fetches never return: without a ctx.Done() check alongside
+// wg.Wait(), cancelling the context would not be honored until every
+// in-flight segment fetch completed.
+func TestForwarder_SkeletonSync_ContextCancellation(t *testing.T) {
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	defer cancelCtx()
+
+	const gapSize = 2000
+
+	bc, err := btclocal.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	btcChain := bc.(*btclocal.Chain)
+	btcChain.SetHeaders(headerRange(0, gapSize))
+
+	blocking := &blockingBtcHandle{Handle: btcChain, unblock: make(chan struct{})}
+	defer close(blocking.unblock)
+
+	forwarder := &Forwarder{
+		btcChain:      blocking,
+		headersQueue:  make(chan *btc.Header, headersQueueSize),
+		errChan:       make(chan error, 1),
+		maxReorgDepth: defaultMaxReorgDepth,
+		recentHeaders: make(map[int64]*btc.Header),
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- forwarder.skeletonSync(ctx, 0, gapSize)
+	}()
+
+	// Give the segment fetch goroutines time to start and block on
+	// GetHeadersByRange before cancelling.
+	time.Sleep(50 * time.Millisecond)
+	cancelCtx()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("expected skeleton sync to return nil on cancellation, got: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("skeleton sync did not honor context cancellation while segment fetches were in flight")
+	}
+}
+
+func TestForwarder_PullingLoop_ReorgRecovery(t *testing.T) {
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	defer cancelCtx()
+
+	headerAt := func(branch byte, height int64) *btc.Header {
+		var hash, prevHash [32]byte
+		hash[30] = branch
+		hash[31] = byte(height)
+		if height > 0 {
+			prevHash[30] = branch
+			prevHash[31] = byte(height - 1)
+		}
+		return &btc.Header{Height: height, Hash: hash, PrevHash: prevHash}
+	}
+
+	// Build an original chain of 10 blocks, all on branch 0.
+	originalHeaders := make([]*btc.Header, 0)
+	for height := int64(1); height <= 10; height++ {
+		originalHeaders = append(originalHeaders, headerAt(0, height))
+	}
+
+	bc, err := btclocal.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	btcChain := bc.(*btclocal.Chain)
+	btcChain.SetHeaders(originalHeaders)
+
+	lc, err := chainlocal.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	localChain := lc.(*chainlocal.Chain)
+	// Host chain starts out aware of the genesis-ish block at height 1.
+	localChain.SetBestKnownDigest(headerAt(0, 1).Hash)
+
+	forwarder := &Forwarder{
+		btcChain:      btcChain,
+		hostChain:     localChain,
+		headersQueue:  make(chan *btc.Header, headersQueueSize),
+		errChan:       make(chan error, 1),
+		maxReorgDepth: 5,
+		recentHeaders: make(map[int64]*btc.Header),
+	}
+
+	// Only run the pulling loop: draining the queue ourselves lets us
+	// observe every header it produces, instead of racing the pushing
+	// loop for them.
+	go forwarder.pullingLoop(ctx)
+
+	// Give the pulling loop time to catch up to height 10 and populate its
+	// remembered headers.
+	time.Sleep(200 * time.Millisecond)
+
+	// Reorg blocks 6 through 10 onto a new branch, keeping 1 through 5
+	// (the common ancestor, at height 5) unchanged.
+	reorgedHeaders := make([]*btc.Header, 0)
+	for height := int64(1); height <= 5; height++ {
+		reorgedHeaders = append(reorgedHeaders, headerAt(0, height))
+	}
+	for height := int64(6); height <= 10; height++ {
+		reorgedHeaders = append(reorgedHeaders, headerAt(1, height))
+	}
+	btcChain.SetHeaders(reorgedHeaders)
+
+	// Drain the queue, collecting headers until we see the new branch's
+	// block 10 or the test times out.
+	deadline := time.After(5 * time.Second)
+	found := false
+	for !found {
+		select {
+		case header := <-forwarder.headersQueue:
+			if header.Height == 10 && header.Hash == headerAt(1, 10).Hash {
+				found = true
+			}
+		case err := <-forwarder.ErrChan():
+			t.Fatalf("forwarder reported an unexpected fatal error: %v", err)
+		case <-deadline:
+			t.Fatal("test timeout has been exceeded")
+		}
+	}
+}
+
+// TestForwarder_PullingLoop_ReorgRecovery_AlreadyCaughtUp reproduces the
+// steady-state case where the forwarder starts out already caught up to
+// the Bitcoin tip and pulls no header beyond that single tip before a
+// shallow reorg hits it. Unlike TestForwarder_PullingLoop_ReorgRecovery, it does
+// not give the pulling loop time to pull fresh headers and build up
+// remembered history first.
+func TestForwarder_PullingLoop_ReorgRecovery_AlreadyCaughtUp(t *testing.T) {
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	defer cancelCtx()
+
+	headerAt := func(branch byte, height int64) *btc.Header {
+		var hash, prevHash [32]byte
+		hash[30] = branch
+		hash[31] = byte(height)
+		if height > 0 {
+			prevHash[30] = branch
+			prevHash[31] = byte(height - 1)
+		}
+		return &btc.Header{Height: height, Hash: hash, PrevHash: prevHash}
+	}
+
+	// Build an original chain of 10 blocks, all on branch 0.
+	originalHeaders := make([]*btc.Header, 0)
+	for height := int64(1); height <= 10; height++ {
+		originalHeaders = append(originalHeaders, headerAt(0, height))
+	}
+
+	bc, err := btclocal.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	btcChain := bc.(*btclocal.Chain)
+	btcChain.SetHeaders(originalHeaders)
+
+	lc, err := chainlocal.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	localChain := lc.(*chainlocal.Chain)
+	// Host chain starts out already caught up to the tip.
+	localChain.SetBestKnownDigest(headerAt(0, 10).Hash)
+
+	forwarder := &Forwarder{
+		btcChain:      btcChain,
+		hostChain:     localChain,
+		headersQueue:  make(chan *btc.Header, headersQueueSize),
+		errChan:       make(chan error, 1),
+		maxReorgDepth: 5,
+		recentHeaders: make(map[int64]*btc.Header),
+	}
+
+	// Only run the pulling loop: draining the queue ourselves lets us
+	// observe every header it produces, instead of racing the pushing
+	// loop for them.
+	go forwarder.pullingLoop(ctx)
+
+	// Reorg only block 10 onto a new branch, well within maxReorgDepth,
+	// without giving the pulling loop any time to pull fresh headers and
+	// build up remembered history beyond the tip it resolved at startup.
+	reorgedHeaders := append([]*btc.Header{}, originalHeaders[:9]...)
+	reorgedHeaders = append(reorgedHeaders, headerAt(1, 10))
+	btcChain.SetHeaders(reorgedHeaders)
+
+	deadline := time.After(5 * time.Second)
+	found := false
+	for !found {
+		select {
+		case header := <-forwarder.headersQueue:
+			if header.Height == 10 && header.Hash == headerAt(1, 10).Hash {
+				found = true
+			}
+		case err := <-forwarder.ErrChan():
+			t.Fatalf("forwarder reported an unexpected fatal error: %v", err)
+		case <-deadline:
+			t.Fatal("test timeout has been exceeded")
+		}
+	}
+}
+
 func TestForwarder_PushingLoop_ErrorShutdown(t *testing.T) {
 	ctx, cancelCtx := context.WithCancel(context.Background())
 	defer cancelCtx()
@@ -179,12 +737,18 @@ func TestForwarder_PushingLoop_ErrorShutdown(t *testing.T) {
 		{Hash: [32]byte{255}, Height: 255, PrevHash: [32]byte{254}},
 	})
 
-	localChain, err := chainlocal.Connect()
+	lc, err := chainlocal.Connect()
 	if err != nil {
 		t.Fatal(err)
 	}
+	localChain := lc.(*chainlocal.Chain)
+
+	// Seed the host chain's best known digest with the Bitcoin chain's only
+	// header so the pulling loop resolves its tip and goes to sleep instead
+	// of racing the pushing loop for the error channel.
+	localChain.SetBestKnownDigest([32]byte{255})
 
-	forwarder := RunForwarder(ctx, btcChain, localChain)
+	forwarder := RunForwarder(ctx, []btc.Handle{btcChain}, localChain)
 
 	// Fill the queue with two headers batches.
 	for i := 1; i <= 10; i++ {
@@ -0,0 +1,137 @@
+package block
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Prometheus metrics exported by the block forwarder, modeled after the
+// metrics go-ethereum's downloader exposes for its own sync pipeline.
+var (
+	headersPulledTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "relay",
+		Subsystem: "block_forwarder",
+		Name:      "headers_pulled_total",
+		Help:      "Total number of Bitcoin headers pulled from the Bitcoin chain.",
+	})
+
+	headersPushedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "relay",
+		Subsystem: "block_forwarder",
+		Name:      "headers_pushed_total",
+		Help:      "Total number of Bitcoin headers pushed to the host chain.",
+	})
+
+	headersQueueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "relay",
+		Subsystem: "block_forwarder",
+		Name:      "headers_queue_depth",
+		Help:      "Current number of headers waiting in the forwarder's headers queue.",
+	})
+
+	pushLatencySeconds = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "relay",
+		Subsystem: "block_forwarder",
+		Name:      "push_latency_seconds",
+		Help:      "Time spent pushing a batch of headers to the host chain.",
+		Buckets:   prometheus.DefBuckets,
+	})
+
+	retargetsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "relay",
+		Subsystem: "block_forwarder",
+		Name:      "retargets_total",
+		Help:      "Total number of difficulty retarget proofs submitted to the host chain.",
+	})
+
+	reorgsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "relay",
+		Subsystem: "block_forwarder",
+		Name:      "reorgs_total",
+		Help:      "Total number of Bitcoin chain reorgs detected and recovered from.",
+	})
+
+	bestHeight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "relay",
+		Subsystem: "block_forwarder",
+		Name:      "best_height",
+		Help:      "Height of the best Bitcoin header known to be pushed to the host chain.",
+	})
+
+	currentSyncMode = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "relay",
+		Subsystem: "block_forwarder",
+		Name:      "sync_mode",
+		Help:      "Current sync mode of the pulling loop (0 = FullSync, 1 = SkeletonSync).",
+	})
+
+	errorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "relay",
+		Subsystem: "block_forwarder",
+		Name:      "errors_total",
+		Help:      "Total number of errors encountered by the forwarder, by category.",
+	}, []string{"category"})
+
+	fetcherAnnouncementsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "relay",
+		Subsystem: "block_fetcher",
+		Name:      "announcements_total",
+		Help:      "Total number of new head announcements received by the fetcher.",
+	})
+
+	fetcherForwardedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "relay",
+		Subsystem: "block_fetcher",
+		Name:      "forwarded_total",
+		Help:      "Total number of announced headers pushed directly to the host chain by the fetcher.",
+	})
+
+	fetcherOrphansTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "relay",
+		Subsystem: "block_fetcher",
+		Name:      "orphans_total",
+		Help:      "Total number of announced headers queued as orphans pending their parent.",
+	})
+
+	fetcherYieldedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "relay",
+		Subsystem: "block_fetcher",
+		Name:      "yielded_total",
+		Help:      "Total number of announcements dropped because the forwarder's lag exceeded the yield threshold.",
+	})
+
+	fetcherErrorsDroppedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "relay",
+		Subsystem: "block_fetcher",
+		Name:      "errors_dropped_total",
+		Help:      "Total number of non-fatal fetcher errors dropped because errChan was still full.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(
+		headersPulledTotal,
+		headersPushedTotal,
+		headersQueueDepth,
+		pushLatencySeconds,
+		retargetsTotal,
+		reorgsTotal,
+		bestHeight,
+		currentSyncMode,
+		errorsTotal,
+		fetcherAnnouncementsTotal,
+		fetcherForwardedTotal,
+		fetcherOrphansTotal,
+		fetcherYieldedTotal,
+		fetcherErrorsDroppedTotal,
+	)
+}
+
+// RegisterMetricsHandler registers the Prometheus metrics HTTP handler on
+// the given mux under /metrics, so operators can scrape the forwarder's
+// metrics.
+func RegisterMetricsHandler(mux *http.ServeMux) {
+	mux.Handle("/metrics", promhttp.Handler())
+}
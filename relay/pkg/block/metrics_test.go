@@ -0,0 +1,40 @@
+package block
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestRegisterMetricsHandler_Scrapable checks that RegisterMetricsHandler
+// wires the Prometheus handler onto the given mux at /metrics and that the
+// response it serves is actually scrapable, rather than just asserting the
+// underlying counters update in isolation.
+func TestRegisterMetricsHandler_Scrapable(t *testing.T) {
+	mux := http.NewServeMux()
+	RegisterMetricsHandler(mux)
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status code: [%v]", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(body), "relay_block_forwarder_sync_mode") {
+		t.Error("expected scraped response to contain the sync mode metric")
+	}
+}
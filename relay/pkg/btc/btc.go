@@ -0,0 +1,36 @@
+// Package btc provides access to the Bitcoin chain the relay pulls
+// headers from.
+package btc
+
+// Header represents a Bitcoin block header tracked by the relay.
+type Header struct {
+	Height   int64
+	Hash     [32]byte
+	PrevHash [32]byte
+}
+
+// Handle represents a read-only handle to the Bitcoin chain used by the
+// relay to discover and pull new headers.
+type Handle interface {
+	// GetHeaderByDigest returns the header identified by the given digest.
+	// The digest is expected to be the double SHA-256 hash of the header,
+	// as understood by the Bitcoin protocol.
+	GetHeaderByDigest(digest [32]byte) (*Header, error)
+
+	// GetHeaderByHeight returns the header at the given height on the
+	// current Bitcoin main chain.
+	GetHeaderByHeight(height int64) (*Header, error)
+
+	// GetBestHeight returns the height of the best (tip) header known
+	// to the Bitcoin chain.
+	GetBestHeight() (int64, error)
+
+	// GetHeadersByRange returns count consecutive headers from the current
+	// Bitcoin main chain, starting at startHeight.
+	GetHeadersByRange(startHeight int64, count int64) ([]*Header, error)
+
+	// SubscribeNewHead subscribes to newly announced Bitcoin tip headers,
+	// delivering them on headers as they arrive. The returned function
+	// cancels the subscription.
+	SubscribeNewHead(headers chan<- *Header) (unsubscribe func())
+}
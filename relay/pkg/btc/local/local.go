@@ -0,0 +1,164 @@
+// Package local provides a local, in-memory implementation of the btc.Handle
+// interface, meant to be used in tests.
+package local
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/ipfs/go-log"
+	"github.com/keep-network/tbtc/relay/pkg/btc"
+)
+
+var logger = log.Logger("relay-btc-local")
+
+// Chain is a local implementation of the Bitcoin chain handle.
+type Chain struct {
+	mutex sync.RWMutex
+
+	// byHeight holds the headers that currently make up the main chain.
+	byHeight map[int64]*btc.Header
+	// byDigest holds every header ever observed, including ones that were
+	// later reorged away, so that stale digests can still be resolved.
+	byDigest map[[32]byte]*btc.Header
+
+	bestHeight int64
+
+	// subscribers holds the channels registered through SubscribeNewHead,
+	// keyed by an opaque subscription id.
+	subscribers   map[int]chan<- *btc.Header
+	nextSubscribe int
+}
+
+// Connect performs initialization for communication with the local,
+// in-memory Bitcoin chain.
+func Connect() (btc.Handle, error) {
+	logger.Infof("connecting local btc chain")
+
+	return &Chain{
+		byHeight:    make(map[int64]*btc.Header),
+		byDigest:    make(map[[32]byte]*btc.Header),
+		subscribers: make(map[int]chan<- *btc.Header),
+	}, nil
+}
+
+// SetHeaders replaces the current main chain with the given headers. Headers
+// that were part of a previously set main chain remain resolvable by digest,
+// simulating the way a Bitcoin node keeps track of stale chain tips.
+func (c *Chain) SetHeaders(headers []*btc.Header) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	byHeight := make(map[int64]*btc.Header, len(headers))
+
+	var bestHeight int64
+	for _, header := range headers {
+		byHeight[header.Height] = header
+		c.byDigest[header.Hash] = header
+
+		if header.Height > bestHeight {
+			bestHeight = header.Height
+		}
+	}
+
+	c.byHeight = byHeight
+	c.bestHeight = bestHeight
+}
+
+// SubscribeNewHead registers headers to receive newly announced tip headers,
+// simulating the push notifications a real Bitcoin node would deliver to its
+// subscribers. The returned function cancels the subscription.
+func (c *Chain) SubscribeNewHead(headers chan<- *btc.Header) func() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	id := c.nextSubscribe
+	c.nextSubscribe++
+	c.subscribers[id] = headers
+
+	return func() {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+
+		delete(c.subscribers, id)
+	}
+}
+
+// AnnounceHead sets header as the new tip of the main chain and notifies all
+// subscribers registered through SubscribeNewHead, simulating a Bitcoin node
+// announcing a newly mined block.
+func (c *Chain) AnnounceHead(header *btc.Header) {
+	c.mutex.Lock()
+	c.byHeight[header.Height] = header
+	c.byDigest[header.Hash] = header
+	if header.Height > c.bestHeight {
+		c.bestHeight = header.Height
+	}
+
+	subscribers := make([]chan<- *btc.Header, 0, len(c.subscribers))
+	for _, subscriber := range c.subscribers {
+		subscribers = append(subscribers, subscriber)
+	}
+	c.mutex.Unlock()
+
+	for _, subscriber := range subscribers {
+		subscriber <- header
+	}
+}
+
+// GetHeaderByDigest returns the header identified by the given digest.
+func (c *Chain) GetHeaderByDigest(digest [32]byte) (*btc.Header, error) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	header, ok := c.byDigest[digest]
+	if !ok {
+		return nil, fmt.Errorf("no header with digest [%x]", digest)
+	}
+
+	return header, nil
+}
+
+// GetHeaderByHeight returns the header at the given height on the current
+// main chain.
+func (c *Chain) GetHeaderByHeight(height int64) (*btc.Header, error) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	header, ok := c.byHeight[height]
+	if !ok {
+		return nil, fmt.Errorf("no header at height [%v]", height)
+	}
+
+	return header, nil
+}
+
+// GetBestHeight returns the height of the best header of the current main
+// chain.
+func (c *Chain) GetBestHeight() (int64, error) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.bestHeight, nil
+}
+
+// GetHeadersByRange returns count consecutive headers from the current main
+// chain, starting at startHeight.
+func (c *Chain) GetHeadersByRange(
+	startHeight int64,
+	count int64,
+) ([]*btc.Header, error) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	headers := make([]*btc.Header, 0, count)
+	for height := startHeight; height < startHeight+count; height++ {
+		header, ok := c.byHeight[height]
+		if !ok {
+			return nil, fmt.Errorf("no header at height [%v]", height)
+		}
+		headers = append(headers, header)
+	}
+
+	return headers, nil
+}
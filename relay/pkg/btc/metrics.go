@@ -0,0 +1,17 @@
+package btc
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// multiChainDisagreementsTotal counts the number of times a MultiChain
+// lookup saw at least one source disagree with the most-reported header,
+// whether or not that header reached quorum.
+var multiChainDisagreementsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: "relay",
+	Subsystem: "btc_multichain",
+	Name:      "disagreements_total",
+	Help:      "Total number of MultiChain lookups in which at least one Bitcoin source disagreed with the most-reported header.",
+})
+
+func init() {
+	prometheus.MustRegister(multiChainDisagreementsTotal)
+}
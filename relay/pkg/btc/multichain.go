@@ -0,0 +1,394 @@
+package btc
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-log"
+)
+
+const (
+	// announceVoteTTL is how long a new-head announcement from one source
+	// is kept around waiting for enough of the other configured sources to
+	// report the same header before it is discarded.
+	announceVoteTTL = 30 * time.Second
+
+	// announceVoteSweepInterval is how often expired announcement votes
+	// are purged.
+	announceVoteSweepInterval = 10 * time.Second
+
+	// sourceAnnouncementsChannelSize is the size of the channel each
+	// configured source's announcements are relayed through before being
+	// tallied.
+	sourceAnnouncementsChannelSize = 16
+)
+
+var multiChainLogger = log.Logger("relay-btc-multichain")
+
+// headerKey identifies a header by the tuple Bitcoin itself relies on to
+// tell headers apart: its own hash and the hash of its parent.
+type headerKey struct {
+	hash     [32]byte
+	prevHash [32]byte
+}
+
+// MultiChain fans out reads across multiple Bitcoin chain handles and only
+// trusts a header once at least a quorum fraction of the configured
+// sources agree on it, protecting the relay operator from a single
+// compromised or forked Bitcoin node feeding bad headers to the host
+// chain.
+type MultiChain struct {
+	handles []Handle
+	quorum  float64
+}
+
+// NewMultiChain creates a MultiChain fanning out over the given handles.
+// quorum is the fraction, between 0 (exclusive) and 1 (inclusive), of
+// handles that must agree on a header before it is trusted.
+func NewMultiChain(handles []Handle, quorum float64) *MultiChain {
+	return &MultiChain{handles: handles, quorum: quorum}
+}
+
+func (m *MultiChain) requiredAgreement() int {
+	return int(math.Ceil(m.quorum * float64(len(m.handles))))
+}
+
+// GetHeaderByHeight fans out GetHeaderByHeight across all configured
+// handles and returns the header reported by at least the configured
+// quorum fraction of them.
+func (m *MultiChain) GetHeaderByHeight(height int64) (*Header, error) {
+	return m.quorumHeader(fmt.Sprintf("height [%v]", height), func(h Handle) (*Header, error) {
+		return h.GetHeaderByHeight(height)
+	})
+}
+
+// GetHeaderByDigest fans out GetHeaderByDigest across all configured
+// handles and returns the header reported by at least the configured
+// quorum fraction of them.
+func (m *MultiChain) GetHeaderByDigest(digest [32]byte) (*Header, error) {
+	return m.quorumHeader(fmt.Sprintf("digest [%x]", digest), func(h Handle) (*Header, error) {
+		return h.GetHeaderByDigest(digest)
+	})
+}
+
+// GetBestHeight returns the lowest best height reported across all
+// configured handles, so that subsequent quorum lookups never ask a
+// lagging source for a height it does not have yet.
+func (m *MultiChain) GetBestHeight() (int64, error) {
+	heights := make([]int64, len(m.handles))
+	errs := make([]error, len(m.handles))
+
+	var wg sync.WaitGroup
+	for i, handle := range m.handles {
+		wg.Add(1)
+		go func(i int, handle Handle) {
+			defer wg.Done()
+			heights[i], errs[i] = handle.GetBestHeight()
+		}(i, handle)
+	}
+	wg.Wait()
+
+	best := int64(-1)
+	for i, err := range errs {
+		if err != nil {
+			return 0, fmt.Errorf(
+				"could not get best height from source [%v]: [%v]",
+				i,
+				err,
+			)
+		}
+		if best == -1 || heights[i] < best {
+			best = heights[i]
+		}
+	}
+
+	return best, nil
+}
+
+// GetHeadersByRange fans out GetHeadersByRange across all configured
+// handles and returns, for each height in the range, the header reported
+// by at least the configured quorum fraction of them.
+func (m *MultiChain) GetHeadersByRange(startHeight int64, count int64) ([]*Header, error) {
+	results := make([][]*Header, len(m.handles))
+	errs := make([]error, len(m.handles))
+
+	var wg sync.WaitGroup
+	for i, handle := range m.handles {
+		wg.Add(1)
+		go func(i int, handle Handle) {
+			defer wg.Done()
+			results[i], errs[i] = handle.GetHeadersByRange(startHeight, count)
+		}(i, handle)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf(
+				"could not get headers by range from source [%v]: [%v]",
+				i,
+				err,
+			)
+		}
+	}
+
+	headers := make([]*Header, count)
+	for offset := int64(0); offset < count; offset++ {
+		candidates := make([]*Header, len(results))
+		for i, sourceHeaders := range results {
+			if offset < int64(len(sourceHeaders)) {
+				candidates[i] = sourceHeaders[offset]
+			}
+		}
+
+		header, err := m.quorumOf(
+			fmt.Sprintf("height [%v]", startHeight+offset),
+			candidates,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		headers[offset] = header
+	}
+
+	return headers, nil
+}
+
+// sourceAnnouncement pairs a newly announced header with the index of the
+// configured source that reported it, so the quorum tally can tell two
+// votes from the same source apart from two votes from distinct sources.
+type sourceAnnouncement struct {
+	source int
+	header *Header
+}
+
+// announceVote tallies the distinct sources that have reported a given
+// announced header, so it can be released once the configured quorum
+// fraction of sources agree on it.
+type announceVote struct {
+	header    *Header
+	voters    map[int]bool
+	expiresAt time.Time
+}
+
+// SubscribeNewHead subscribes to newly announced tip headers from every
+// configured source and only delivers an announcement on headers once at
+// least the configured quorum fraction of sources have reported the same
+// header, protecting callers (such as the block Fetcher) from a single
+// compromised or forked source the same way every other MultiChain method
+// already does. The returned function cancels the subscription.
+func (m *MultiChain) SubscribeNewHead(headers chan<- *Header) func() {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	announcements := make(chan sourceAnnouncement, len(m.handles)*sourceAnnouncementsChannelSize)
+
+	unsubscribes := make([]func(), len(m.handles))
+	for i, handle := range m.handles {
+		sourceHeaders := make(chan *Header, sourceAnnouncementsChannelSize)
+		unsubscribes[i] = handle.SubscribeNewHead(sourceHeaders)
+		go relaySourceAnnouncements(ctx, i, sourceHeaders, announcements)
+	}
+
+	go m.quorumAnnounceLoop(ctx, announcements, headers)
+
+	return func() {
+		cancel()
+		for _, unsubscribe := range unsubscribes {
+			unsubscribe()
+		}
+	}
+}
+
+// relaySourceAnnouncements tags every header received on sourceHeaders with
+// source and relays it on announcements, until ctx is done.
+func relaySourceAnnouncements(
+	ctx context.Context,
+	source int,
+	sourceHeaders <-chan *Header,
+	announcements chan<- sourceAnnouncement,
+) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case header := <-sourceHeaders:
+			select {
+			case announcements <- sourceAnnouncement{source: source, header: header}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// quorumAnnounceLoop tallies incoming announcements by (Hash, PrevHash),
+// keyed per source so a single source cannot cast more than one vote for the
+// same header, and delivers a header on headers as soon as enough distinct
+// sources have reported it to meet the configured quorum. Votes that never
+// reach quorum within announceVoteTTL are discarded.
+func (m *MultiChain) quorumAnnounceLoop(
+	ctx context.Context,
+	announcements <-chan sourceAnnouncement,
+	headers chan<- *Header,
+) {
+	votes := make(map[headerKey]*announceVote)
+
+	sweepTicker := time.NewTicker(announceVoteSweepInterval)
+	defer sweepTicker.Stop()
+
+	required := m.requiredAgreement()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case announcement := <-announcements:
+			key := headerKey{
+				hash:     announcement.header.Hash,
+				prevHash: announcement.header.PrevHash,
+			}
+
+			vote, ok := votes[key]
+			if !ok {
+				vote = &announceVote{
+					header:    announcement.header,
+					voters:    make(map[int]bool),
+					expiresAt: time.Now().Add(announceVoteTTL),
+				}
+				votes[key] = vote
+			}
+			vote.voters[announcement.source] = true
+
+			if len(vote.voters) < required {
+				continue
+			}
+
+			delete(votes, key)
+
+			select {
+			case headers <- vote.header:
+			case <-ctx.Done():
+				return
+			}
+		case <-sweepTicker.C:
+			now := time.Now()
+			for key, vote := range votes {
+				if now.After(vote.expiresAt) {
+					delete(votes, key)
+				}
+			}
+		}
+	}
+}
+
+// quorumHeader fetches a header from every configured handle using fetch
+// and returns the one reported by at least the configured quorum fraction.
+func (m *MultiChain) quorumHeader(
+	subject string,
+	fetch func(Handle) (*Header, error),
+) (*Header, error) {
+	candidates := make([]*Header, len(m.handles))
+	errs := make([]error, len(m.handles))
+
+	var wg sync.WaitGroup
+	for i, handle := range m.handles {
+		wg.Add(1)
+		go func(i int, handle Handle) {
+			defer wg.Done()
+			candidates[i], errs[i] = fetch(handle)
+		}(i, handle)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			if len(m.handles) == 1 {
+				return nil, err
+			}
+			return nil, fmt.Errorf(
+				"could not get header for %v from source [%v]: [%v]",
+				subject,
+				i,
+				err,
+			)
+		}
+	}
+
+	return m.quorumOf(subject, candidates)
+}
+
+// quorumOf tallies candidates by (Hash, PrevHash) and returns the one
+// reported by at least the configured quorum fraction of sources, logging
+// and accounting for any disagreement.
+func (m *MultiChain) quorumOf(subject string, candidates []*Header) (*Header, error) {
+	tally := make(map[headerKey]int)
+	headerOf := make(map[headerKey]*Header)
+
+	for _, candidate := range candidates {
+		if candidate == nil {
+			continue
+		}
+
+		key := headerKey{hash: candidate.Hash, prevHash: candidate.PrevHash}
+		tally[key]++
+		headerOf[key] = candidate
+	}
+
+	required := m.requiredAgreement()
+
+	var winner *Header
+	var winnerVotes int
+	tied := false
+	for key, votes := range tally {
+		switch {
+		case votes > winnerVotes:
+			winner = headerOf[key]
+			winnerVotes = votes
+			tied = false
+		case votes == winnerVotes && winnerVotes > 0:
+			tied = true
+		}
+	}
+
+	// A tie between two disjoint candidates, each already meeting quorum,
+	// would otherwise be broken by Go's randomized map iteration order,
+	// letting an attacker-chosen header win a re-poll of the same source
+	// split as easily as the legitimate one. Refuse instead of guessing.
+	if tied && winnerVotes >= required {
+		return nil, fmt.Errorf(
+			"no quorum reached for %v: [%v] sources tied at [%v] votes each, "+
+				"refusing to pick a winner",
+			subject,
+			len(candidates),
+			winnerVotes,
+		)
+	}
+
+	if winnerVotes < len(candidates) {
+		multiChainDisagreementsTotal.Inc()
+		multiChainLogger.Warningf(
+			"Bitcoin sources disagree on header for %v: "+
+				"[%v] of [%v] sources agree on the winning header",
+			subject,
+			winnerVotes,
+			len(candidates),
+		)
+	}
+
+	if winner == nil || winnerVotes < required {
+		return nil, fmt.Errorf(
+			"no quorum reached for %v: only [%v] of [%v] sources agree, "+
+				"[%v] required",
+			subject,
+			winnerVotes,
+			len(candidates),
+			required,
+		)
+	}
+
+	return winner, nil
+}
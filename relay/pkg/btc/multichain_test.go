@@ -0,0 +1,175 @@
+package btc_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/keep-network/tbtc/relay/pkg/btc"
+	"github.com/keep-network/tbtc/relay/pkg/btc/local"
+)
+
+func connectLocalChain(t *testing.T, headers []*btc.Header) *local.Chain {
+	t.Helper()
+
+	handle, err := local.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chain := handle.(*local.Chain)
+	chain.SetHeaders(headers)
+
+	return chain
+}
+
+func TestMultiChain_GetHeaderByHeight_QuorumReached(t *testing.T) {
+	agreeingTail := []*btc.Header{
+		{Height: 1, Hash: [32]byte{1}, PrevHash: [32]byte{0}},
+	}
+	divergingTail := []*btc.Header{
+		{Height: 1, Hash: [32]byte{0xff}, PrevHash: [32]byte{0}},
+	}
+
+	sources := []btc.Handle{
+		connectLocalChain(t, agreeingTail),
+		connectLocalChain(t, agreeingTail),
+		connectLocalChain(t, divergingTail),
+	}
+
+	multiChain := btc.NewMultiChain(sources, 0.66)
+
+	header, err := multiChain.GetHeaderByHeight(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if header.Hash != agreeingTail[0].Hash {
+		t.Errorf(
+			"unexpected header hash:\n"+
+				"expected: [%x]\n"+
+				"actual:   [%x]\n",
+			agreeingTail[0].Hash,
+			header.Hash,
+		)
+	}
+}
+
+func TestMultiChain_GetHeaderByHeight_QuorumNotReached(t *testing.T) {
+	sources := []btc.Handle{
+		connectLocalChain(t, []*btc.Header{
+			{Height: 1, Hash: [32]byte{1}, PrevHash: [32]byte{0}},
+		}),
+		connectLocalChain(t, []*btc.Header{
+			{Height: 1, Hash: [32]byte{2}, PrevHash: [32]byte{0}},
+		}),
+		connectLocalChain(t, []*btc.Header{
+			{Height: 1, Hash: [32]byte{3}, PrevHash: [32]byte{0}},
+		}),
+	}
+
+	multiChain := btc.NewMultiChain(sources, 0.66)
+
+	if _, err := multiChain.GetHeaderByHeight(1); err == nil {
+		t.Error("expected an error as no source reaches quorum")
+	}
+}
+
+// TestMultiChain_GetHeaderByHeight_QuorumTied checks that an even split
+// across sources, where two disjoint candidates each independently reach
+// the required quorum count, is rejected rather than resolved by Go's
+// randomized map iteration order.
+func TestMultiChain_GetHeaderByHeight_QuorumTied(t *testing.T) {
+	branchA := []*btc.Header{
+		{Height: 1, Hash: [32]byte{0xaa}, PrevHash: [32]byte{0}},
+	}
+	branchB := []*btc.Header{
+		{Height: 1, Hash: [32]byte{0xbb}, PrevHash: [32]byte{0}},
+	}
+
+	sources := []btc.Handle{
+		connectLocalChain(t, branchA),
+		connectLocalChain(t, branchA),
+		connectLocalChain(t, branchB),
+		connectLocalChain(t, branchB),
+	}
+
+	multiChain := btc.NewMultiChain(sources, 0.5)
+
+	for i := 0; i < 10; i++ {
+		if _, err := multiChain.GetHeaderByHeight(1); err == nil {
+			t.Fatal("expected an error as the sources are evenly split")
+		}
+	}
+}
+
+// TestMultiChain_SubscribeNewHead_QuorumReached checks that an announcement
+// is delivered once enough distinct sources report the same header, even
+// though they report it at different times.
+func TestMultiChain_SubscribeNewHead_QuorumReached(t *testing.T) {
+	genesis := &btc.Header{Height: 0, Hash: [32]byte{0}}
+	sourceA := connectLocalChain(t, []*btc.Header{genesis})
+	sourceB := connectLocalChain(t, []*btc.Header{genesis})
+	sourceC := connectLocalChain(t, []*btc.Header{genesis})
+
+	multiChain := btc.NewMultiChain(
+		[]btc.Handle{sourceA, sourceB, sourceC},
+		0.66,
+	)
+
+	announcements := make(chan *btc.Header, 1)
+	unsubscribe := multiChain.SubscribeNewHead(announcements)
+	defer unsubscribe()
+
+	announced := &btc.Header{Height: 1, Hash: [32]byte{1}, PrevHash: [32]byte{0}}
+
+	sourceA.AnnounceHead(announced)
+
+	select {
+	case <-announcements:
+		t.Fatal("announcement delivered before quorum was reached")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	sourceB.AnnounceHead(announced)
+
+	select {
+	case header := <-announcements:
+		if header.Hash != announced.Hash {
+			t.Errorf(
+				"unexpected header hash:\nexpected: [%x]\nactual:   [%x]\n",
+				announced.Hash,
+				header.Hash,
+			)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("announcement was never delivered after quorum was reached")
+	}
+}
+
+// TestMultiChain_SubscribeNewHead_QuorumNotReached checks that sources
+// disagreeing on the announced header never unblocks delivery.
+func TestMultiChain_SubscribeNewHead_QuorumNotReached(t *testing.T) {
+	genesis := &btc.Header{Height: 0, Hash: [32]byte{0}}
+	sourceA := connectLocalChain(t, []*btc.Header{genesis})
+	sourceB := connectLocalChain(t, []*btc.Header{genesis})
+	sourceC := connectLocalChain(t, []*btc.Header{genesis})
+
+	multiChain := btc.NewMultiChain(
+		[]btc.Handle{sourceA, sourceB, sourceC},
+		0.66,
+	)
+
+	announcements := make(chan *btc.Header, 1)
+	unsubscribe := multiChain.SubscribeNewHead(announcements)
+	defer unsubscribe()
+
+	sourceA.AnnounceHead(&btc.Header{Height: 1, Hash: [32]byte{1}, PrevHash: [32]byte{0}})
+	sourceB.AnnounceHead(&btc.Header{Height: 1, Hash: [32]byte{2}, PrevHash: [32]byte{0}})
+	sourceC.AnnounceHead(&btc.Header{Height: 1, Hash: [32]byte{3}, PrevHash: [32]byte{0}})
+
+	select {
+	case header := <-announcements:
+		t.Fatalf("unexpected announcement delivered without quorum: [%x]", header.Hash)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
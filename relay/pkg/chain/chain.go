@@ -0,0 +1,30 @@
+// Package chain provides access to the host chain the relay forwards
+// Bitcoin headers to.
+package chain
+
+import "github.com/keep-network/tbtc/relay/pkg/btc"
+
+// Handle represents a handle to the host chain hosting the relay contract.
+type Handle interface {
+	// GetBestKnownDigest returns the digest of the best Bitcoin header
+	// known to the relay contract.
+	GetBestKnownDigest() ([32]byte, error)
+
+	// AddHeaders adds the given headers to the relay contract. anchorDigest
+	// must be the digest of a header already known to the relay contract
+	// and the headers must form an unbroken chain from that anchor, without
+	// crossing a Bitcoin difficulty epoch boundary.
+	AddHeaders(anchorDigest [32]byte, headers []*btc.Header) error
+
+	// AddHeadersWithRetarget adds the given headers to the relay contract,
+	// proving the Bitcoin difficulty retarget that headers[0] introduces.
+	// oldPeriodStart and oldPeriodEnd must be the digests of the first and
+	// last headers of the difficulty epoch that precedes headers[0], both
+	// already known to the relay contract, and are used to verify the new
+	// difficulty.
+	AddHeadersWithRetarget(
+		oldPeriodStart [32]byte,
+		oldPeriodEnd [32]byte,
+		headers []*btc.Header,
+	) error
+}
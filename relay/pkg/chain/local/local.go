@@ -1,23 +1,177 @@
 package local
 
 import (
+	"fmt"
+	"sync"
+
 	"github.com/ipfs/go-log"
+	"github.com/keep-network/tbtc/relay/pkg/btc"
 	"github.com/keep-network/tbtc/relay/pkg/chain"
 )
 
+// difficultyEpochDuration is the block duration of a Bitcoin difficulty
+// epoch, mirroring the same protocol constant enforced by the real relay
+// contract.
+const difficultyEpochDuration = 2016
+
 var logger = log.Logger("relay-chain-local")
 
-// localChain is a local implementation of the host chain interface.
-type localChain struct{}
+// Chain is a local implementation of the host chain interface.
+type Chain struct {
+	mutex sync.RWMutex
+
+	bestKnownDigest [32]byte
+	headersByDigest map[[32]byte]*btc.Header
+}
 
 // Connect performs initialization for communication with the local blockchain.
 func Connect() (chain.Handle, error) {
 	logger.Infof("connecting local host chain")
 
-	return &localChain{}, nil
+	return &Chain{
+		headersByDigest: make(map[[32]byte]*btc.Header),
+	}, nil
 }
 
 // GetBestKnownDigest returns the best known digest.
-func (lc *localChain) GetBestKnownDigest() ([32]uint8, error) {
-	panic("not implemented yet")
+func (c *Chain) GetBestKnownDigest() ([32]byte, error) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.bestKnownDigest, nil
+}
+
+// SetBestKnownDigest sets the best known digest. It is meant to be used in
+// tests to arrange the local chain's state.
+func (c *Chain) SetBestKnownDigest(digest [32]byte) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.bestKnownDigest = digest
+}
+
+// SeedHeader registers the given header as already known by the chain,
+// without requiring it to chain from an existing anchor. It is meant to be
+// used in tests to bootstrap chain state, e.g. a genesis header or a
+// difficulty epoch boundary, before exercising AddHeaders.
+func (c *Chain) SeedHeader(header *btc.Header) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.headersByDigest[header.Hash] = header
+}
+
+// AddHeaders adds the given headers to the chain, anchored on a header
+// already known by digest. anchorDigest must be the chain's current
+// bestKnownDigest.
+func (c *Chain) AddHeaders(anchorDigest [32]byte, headers []*btc.Header) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	anchor, err := c.getHeaderByDigest(anchorDigest)
+	if err != nil {
+		return fmt.Errorf("could not get anchor header by digest: [%v]", err)
+	}
+
+	if err := c.requireCurrentTip(anchorDigest); err != nil {
+		return err
+	}
+
+	return c.chainHeaders(anchor, headers)
+}
+
+// AddHeadersWithRetarget adds the given headers to the chain, proving the
+// difficulty retarget that headers[0] introduces using the first and last
+// headers of the preceding difficulty epoch.
+func (c *Chain) AddHeadersWithRetarget(
+	oldPeriodStart [32]byte,
+	oldPeriodEnd [32]byte,
+	headers []*btc.Header,
+) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	start, err := c.getHeaderByDigest(oldPeriodStart)
+	if err != nil {
+		return fmt.Errorf(
+			"could not get old difficulty period start header: [%v]",
+			err,
+		)
+	}
+
+	end, err := c.getHeaderByDigest(oldPeriodEnd)
+	if err != nil {
+		return fmt.Errorf(
+			"could not get old difficulty period end header: [%v]",
+			err,
+		)
+	}
+
+	if end.Height-start.Height != difficultyEpochDuration-1 {
+		return fmt.Errorf(
+			"old difficulty period does not span exactly [%v] blocks",
+			difficultyEpochDuration,
+		)
+	}
+
+	if err := c.requireCurrentTip(oldPeriodEnd); err != nil {
+		return err
+	}
+
+	return c.chainHeaders(end, headers)
+}
+
+// requireCurrentTip rejects anchorDigest if it is not the chain's current
+// bestKnownDigest. AddHeaders and AddHeadersWithRetarget otherwise only
+// require their anchor to be some previously known header, which is
+// harmless with a single writer but lets a second, independent writer to
+// this chain (e.g. the block Fetcher racing the Forwarder's pushingLoop)
+// successfully anchor on an already superseded tip and silently rewind
+// bestKnownDigest backward.
+func (c *Chain) requireCurrentTip(anchorDigest [32]byte) error {
+	if anchorDigest != c.bestKnownDigest {
+		return fmt.Errorf(
+			"anchor digest [%x] is not the chain's current tip [%x]",
+			anchorDigest,
+			c.bestKnownDigest,
+		)
+	}
+
+	return nil
+}
+
+// chainHeaders validates that headers form an unbroken PrevHash chain
+// starting at anchor, and records them.
+func (c *Chain) chainHeaders(anchor *btc.Header, headers []*btc.Header) error {
+	if len(headers) == 0 {
+		return fmt.Errorf("no headers to add")
+	}
+
+	prev := anchor
+	for _, header := range headers {
+		if header.PrevHash != prev.Hash {
+			return fmt.Errorf(
+				"header at height [%v] does not chain from the previous "+
+					"header",
+				header.Height,
+			)
+		}
+		prev = header
+	}
+
+	for _, header := range headers {
+		c.headersByDigest[header.Hash] = header
+	}
+	c.bestKnownDigest = headers[len(headers)-1].Hash
+
+	return nil
+}
+
+func (c *Chain) getHeaderByDigest(digest [32]byte) (*btc.Header, error) {
+	header, ok := c.headersByDigest[digest]
+	if !ok {
+		return nil, fmt.Errorf("no header with digest [%x]", digest)
+	}
+
+	return header, nil
 }
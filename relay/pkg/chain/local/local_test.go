@@ -0,0 +1,75 @@
+package local
+
+import (
+	"testing"
+
+	"github.com/keep-network/tbtc/relay/pkg/btc"
+)
+
+func TestChain_AddHeaders_RejectsStaleAnchor(t *testing.T) {
+	chain := &Chain{
+		headersByDigest: make(map[[32]byte]*btc.Header),
+	}
+
+	genesis := &btc.Header{Height: 0, Hash: [32]byte{0}}
+	chain.SeedHeader(genesis)
+	chain.SetBestKnownDigest(genesis.Hash)
+
+	headerOne := &btc.Header{Height: 1, Hash: [32]byte{1}, PrevHash: genesis.Hash}
+	if err := chain.AddHeaders(genesis.Hash, []*btc.Header{headerOne}); err != nil {
+		t.Fatalf("could not add headers anchored on the current tip: %v", err)
+	}
+
+	// genesis is no longer the chain's tip; anchoring on it again simulates a
+	// second writer (e.g. the block Fetcher) racing the one that just
+	// advanced the tip to headerOne.
+	headerTwo := &btc.Header{Height: 1, Hash: [32]byte{2}, PrevHash: genesis.Hash}
+	if err := chain.AddHeaders(genesis.Hash, []*btc.Header{headerTwo}); err == nil {
+		t.Fatal("expected an error anchoring on a superseded tip")
+	}
+
+	digest, err := chain.GetBestKnownDigest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if digest != headerOne.Hash {
+		t.Fatalf(
+			"expected the rejected stale write to leave the tip unchanged:\n"+
+				"expected: [%x]\n"+
+				"actual:   [%x]\n",
+			headerOne.Hash,
+			digest,
+		)
+	}
+}
+
+func TestChain_AddHeadersWithRetarget_RejectsStaleAnchor(t *testing.T) {
+	chain := &Chain{
+		headersByDigest: make(map[[32]byte]*btc.Header),
+	}
+
+	periodStart := &btc.Header{Height: 0, Hash: [32]byte{0}}
+	periodEnd := &btc.Header{
+		Height: difficultyEpochDuration - 1,
+		Hash:   [32]byte{1},
+	}
+	chain.SeedHeader(periodStart)
+	chain.SeedHeader(periodEnd)
+	chain.SetBestKnownDigest(periodStart.Hash)
+
+	newEpochHeader := &btc.Header{
+		Height:   difficultyEpochDuration,
+		Hash:     [32]byte{2},
+		PrevHash: periodEnd.Hash,
+	}
+
+	// periodStart, not periodEnd, is the chain's current tip, simulating a
+	// retarget proof racing a write that has not reached periodEnd yet.
+	if err := chain.AddHeadersWithRetarget(
+		periodStart.Hash,
+		periodEnd.Hash,
+		[]*btc.Header{newEpochHeader},
+	); err == nil {
+		t.Fatal("expected an error anchoring the retarget on a stale tip")
+	}
+}